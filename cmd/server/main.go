@@ -1,16 +1,67 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 
+	distributedlog "github.com/aleksandarhr/proglog/internal/log/distributed"
 	"github.com/aleksandarhr/proglog/internal/server"
+	"github.com/hashicorp/raft"
+	"github.com/soheilhy/cmux"
 )
 
 func main() {
-	// simply create and start the server, passing the address to listen to
-	port := ":8080"
-	srv := server.NewHTTPServer(port)
-	fmt.Println("Listening on port" + port)
-	log.Fatal(srv.ListenAndServe())
+	dataDir := flag.String("data-dir", "/tmp/proglog", "directory to store the log and Raft state under")
+	bindAddr := flag.String("bind-addr", "127.0.0.1:8400", "address this node listens on for both Raft RPC and gRPC traffic")
+	nodeID := flag.String("node-id", "", "this node's unique Raft server ID (required)")
+	bootstrap := flag.Bool("bootstrap", false, "bootstrap a new single-node Raft cluster using this node")
+	flag.Parse()
+
+	if *nodeID == "" {
+		log.Fatal("proglog: -node-id is required")
+	}
+
+	ln, err := net.Listen("tcp", *bindAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Multiplex Raft RPC and gRPC traffic onto the single listening
+	// socket, distinguishing them by the leading byte each connection
+	// sends (see distributedlog.StreamLayer), so this node only needs to
+	// advertise one address.
+	mux := cmux.New(ln)
+	raftLn := mux.Match(func(r io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := r.Read(b); err != nil {
+			return false
+		}
+		return b[0] == byte(distributedlog.RaftRPC)
+	})
+	grpcLn := mux.Match(cmux.Any())
+
+	var config distributedlog.Config
+	config.Raft.LocalID = raft.ServerID(*nodeID)
+	config.Raft.Bootstrap = *bootstrap
+	config.Raft.StreamLayer = distributedlog.NewStreamLayer(raftLn)
+	dl, err := distributedlog.NewDistributedLog(*dataDir, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	grpcServer, err := server.NewGRPCServer(&server.GRPCConfig{CommitLog: dl})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := grpcServer.Serve(grpcLn); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	fmt.Printf("proglog: node %q listening on %s\n", *nodeID, *bindAddr)
+	log.Fatal(mux.Serve())
 }
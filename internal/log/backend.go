@@ -0,0 +1,176 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadWriteAtCloser is the minimal file-like capability a segment's store
+// and index need from whatever SegmentBackend hands them.
+type ReadWriteAtCloser interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string
+}
+
+// SegmentBackend decides where a segment's store and index files actually
+// live. Log.setup and Log.newSegment go through a backend instead of
+// calling os.OpenFile/ioutil.ReadDir directly, so segment/store/index can
+// stay unaware of whether their bytes end up on local disk, in an object
+// store, or nowhere but memory.
+type SegmentBackend interface {
+	// OpenStore/OpenIndex open (creating if necessary) the store/index
+	// file for the segment starting at baseOffset.
+	OpenStore(baseOffset uint64) (ReadWriteAtCloser, error)
+	OpenIndex(baseOffset uint64) (ReadWriteAtCloser, error)
+
+	// ListBaseOffsets returns the base offset of every segment the
+	// backend currently knows about, ascending, deduplicated (i.e. one
+	// entry per segment, not one per file).
+	ListBaseOffsets() ([]uint64, error)
+
+	// Remove deletes the segment's store and index.
+	Remove(baseOffset uint64) error
+
+	// Seal is called once a segment stops being the active one, i.e. right
+	// after Log rotates to a new segment. It's the backend's chance to do
+	// something with a segment now that it's known to be immutable (e.g.
+	// S3Backend uploads it and evicts the local cache); LocalBackend and
+	// MemoryBackend have nothing to do here.
+	Seal(baseOffset uint64) error
+}
+
+// LocalBackend is the original behavior: every segment's store and index
+// live as plain files in a directory on local disk.
+type LocalBackend struct {
+	dir string
+}
+
+// NewLocalBackend creates a LocalBackend rooted at dir. dir must already
+// exist.
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{dir: dir}
+}
+
+func (b *LocalBackend) storePath(baseOffset uint64) string {
+	return path.Join(b.dir, fmt.Sprintf("%d.store", baseOffset))
+}
+
+func (b *LocalBackend) indexPath(baseOffset uint64) string {
+	return path.Join(b.dir, fmt.Sprintf("%d.index", baseOffset))
+}
+
+func (b *LocalBackend) OpenStore(baseOffset uint64) (ReadWriteAtCloser, error) {
+	return os.OpenFile(b.storePath(baseOffset), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+func (b *LocalBackend) OpenIndex(baseOffset uint64) (ReadWriteAtCloser, error) {
+	return os.OpenFile(b.indexPath(baseOffset), os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// ListBaseOffsets reads the directory and returns the base offset once per
+// segment, looking only at ".store"/".index" files so unrelated files a
+// segment keeps alongside them (the trigram index's ".tri" files, for
+// instance) don't get mistaken for a third segment file.
+func (b *LocalBackend) ListBaseOffsets() ([]uint64, error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]bool)
+	var offsets []uint64
+	for _, file := range files {
+		ext := path.Ext(file.Name())
+		if ext != ".store" && ext != ".index" {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		if !seen[off] {
+			seen[off] = true
+			offsets = append(offsets, off)
+		}
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+func (b *LocalBackend) Remove(baseOffset uint64) error {
+	if err := os.Remove(b.storePath(baseOffset)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(b.indexPath(baseOffset)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Seal is a no-op: a LocalBackend's segments already live wherever they're
+// going to live, active or not.
+func (b *LocalBackend) Seal(baseOffset uint64) error {
+	return nil
+}
+
+// MemoryBackend is a SegmentBackend for tests that don't want to touch the
+// filesystem. It's backed by anonymous temp files rather than a real
+// in-memory buffer, because store and index rely on the file being a real
+// *os.File (index memory-maps it), and unlinking the temp file right after
+// opening it gets the "memory" behavior anyway on any OS with unlink-on-
+// last-close semantics.
+type MemoryBackend struct {
+	segments map[uint64]bool
+}
+
+// NewMemoryBackend creates an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{segments: make(map[uint64]bool)}
+}
+
+func (b *MemoryBackend) tempFile(baseOffset uint64, kind string) (ReadWriteAtCloser, error) {
+	f, err := ioutil.TempFile("", fmt.Sprintf("proglog-mem-%d-%s-", baseOffset, kind))
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(f.Name()) // unlink now; the fd keeps the data alive until Close
+	b.segments[baseOffset] = true
+	return f, nil
+}
+
+func (b *MemoryBackend) OpenStore(baseOffset uint64) (ReadWriteAtCloser, error) {
+	return b.tempFile(baseOffset, "store")
+}
+
+func (b *MemoryBackend) OpenIndex(baseOffset uint64) (ReadWriteAtCloser, error) {
+	return b.tempFile(baseOffset, "index")
+}
+
+func (b *MemoryBackend) ListBaseOffsets() ([]uint64, error) {
+	offsets := make([]uint64, 0, len(b.segments))
+	for off := range b.segments {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+func (b *MemoryBackend) Remove(baseOffset uint64) error {
+	delete(b.segments, baseOffset)
+	return nil
+}
+
+// Seal is a no-op: there's nowhere colder than a MemoryBackend to tier a
+// sealed segment to.
+func (b *MemoryBackend) Seal(baseOffset uint64) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// S3Client is the subset of an S3-compatible object store client
+// S3Backend needs. Kept narrow and unexported-dependency-free so this
+// package doesn't have to import an SDK just to compile; callers wire up
+// a real implementation (e.g. a thin wrapper around aws-sdk-go's s3.Client).
+type S3Client interface {
+	PutObject(bucket, key string, body []byte) error
+	GetObject(bucket, key string) ([]byte, error)
+	DeleteObject(bucket, key string) error
+	ListObjects(bucket, prefix string) ([]string, error)
+}
+
+// S3Backend keeps the active segment's store and index on local disk,
+// same as LocalBackend, so mmap and buffered writes keep working
+// unchanged; sealed (rotated-out) segments get uploaded to S3 and their
+// local copies removed, so cold data doesn't sit on expensive local disk.
+type S3Backend struct {
+	local  *LocalBackend
+	client S3Client
+	bucket string
+	prefix string
+}
+
+// NewS3Backend creates an S3Backend that stages segments under cacheDir
+// before/while they're active and uploads sealed ones under
+// s3://bucket/prefix/<baseOffset>.{store,index}.
+func NewS3Backend(cacheDir string, client S3Client, bucket, prefix string) *S3Backend {
+	return &S3Backend{
+		local:  NewLocalBackend(cacheDir),
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (b *S3Backend) key(baseOffset uint64, kind string) string {
+	return path.Join(b.prefix, fmt.Sprintf("%d.%s", baseOffset, kind))
+}
+
+// OpenStore/OpenIndex always serve the local cache: an active segment is,
+// by definition, still being written to, and object stores don't support
+// the random-access mmap'd writes the index needs. Seal is what moves a
+// segment's data to S3 once Log rotates past it.
+func (b *S3Backend) OpenStore(baseOffset uint64) (ReadWriteAtCloser, error) {
+	if err := b.hydrate(baseOffset, "store"); err != nil {
+		return nil, err
+	}
+	return b.local.OpenStore(baseOffset)
+}
+
+func (b *S3Backend) OpenIndex(baseOffset uint64) (ReadWriteAtCloser, error) {
+	if err := b.hydrate(baseOffset, "index"); err != nil {
+		return nil, err
+	}
+	return b.local.OpenIndex(baseOffset)
+}
+
+// hydrate downloads a sealed segment file back to the local cache before
+// it's reopened (e.g. after a restart evicted the cache but the segment
+// was already uploaded), if it isn't there already and does exist in S3.
+func (b *S3Backend) hydrate(baseOffset uint64, kind string) error {
+	localPath := path.Join(b.local.dir, fmt.Sprintf("%d.%s", baseOffset, kind))
+	if _, err := os.Stat(localPath); err == nil {
+		return nil // already cached locally
+	}
+
+	data, err := b.client.GetObject(b.bucket, b.key(baseOffset, kind))
+	if err != nil {
+		// not sealed in S3 yet (e.g. brand-new segment) — fine, OpenStore/
+		// OpenIndex will create it locally
+		return nil
+	}
+	return ioutil.WriteFile(localPath, data, 0644)
+}
+
+// Seal uploads the given segment's store and index to S3, then removes
+// them from the local cache. Log calls this once a segment stops being
+// the active one, which is what actually moves cold data off local disk.
+func (b *S3Backend) Seal(baseOffset uint64) error {
+	for _, kind := range []string{"store", "index"} {
+		localPath := path.Join(b.local.dir, fmt.Sprintf("%d.%s", baseOffset, kind))
+		data, err := ioutil.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		if err := b.client.PutObject(b.bucket, b.key(baseOffset, kind), data); err != nil {
+			return err
+		}
+	}
+	return b.local.Remove(baseOffset)
+}
+
+// ListBaseOffsets reports segments cached locally plus any sealed to S3
+// but since evicted from the cache.
+func (b *S3Backend) ListBaseOffsets() ([]uint64, error) {
+	local, err := b.local.ListBaseOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]bool, len(local))
+	offsets := append([]uint64{}, local...)
+	for _, off := range local {
+		seen[off] = true
+	}
+
+	keys, err := b.client.ListObjects(b.bucket, b.prefix)
+	if err != nil {
+		return offsets, nil // S3 unreachable: fall back to what's cached locally
+	}
+	for _, k := range keys {
+		var off uint64
+		var kind string
+		if _, err := fmt.Sscanf(path.Base(k), "%d.%s", &off, &kind); err != nil {
+			continue
+		}
+		if !seen[off] {
+			seen[off] = true
+			offsets = append(offsets, off)
+		}
+	}
+	return offsets, nil
+}
+
+func (b *S3Backend) Remove(baseOffset uint64) error {
+	if err := b.local.Remove(baseOffset); err != nil {
+		return err
+	}
+	for _, kind := range []string{"store", "index"} {
+		if err := b.client.DeleteObject(b.bucket, b.key(baseOffset, kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
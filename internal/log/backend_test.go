@@ -0,0 +1,54 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackendDrivesLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory-backend-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backend := NewMemoryBackend()
+	c := Config{Backend: backend}
+	c.Segment.MaxStoreBytes = 32
+
+	lg, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer lg.Close()
+
+	values := [][]byte{[]byte("first record"), []byte("second record"), []byte("third record")}
+	for _, v := range values {
+		_, err := lg.Append(&api.Record{Value: v})
+		require.NoError(t, err)
+	}
+	// a small MaxStoreBytes forces at least one rotation here, which is
+	// what exercises backend.Seal on a non-local backend
+	require.Greater(t, len(lg.segments), 1)
+
+	for i, v := range values {
+		read, err := lg.Read(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, v, read.Value)
+	}
+
+	offsets, err := backend.ListBaseOffsets()
+	require.NoError(t, err)
+	require.Len(t, offsets, len(lg.segments))
+
+	require.NoError(t, lg.Truncate(0))
+}
+
+func TestLocalAndMemoryBackendSealIsNoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seal-noop-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, NewLocalBackend(dir).Seal(0))
+	require.NoError(t, NewMemoryBackend().Seal(0))
+}
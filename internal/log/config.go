@@ -0,0 +1,74 @@
+package log
+
+import "time"
+
+// Config configures a Log and, in turn, every segment/store/index it
+// creates. Zero values are valid; NewLog fills in sane defaults for the
+// fields that need one.
+type Config struct {
+	// Backend decides where segments' store/index files live. Defaults to
+	// a LocalBackend rooted at the Log's Dir when nil.
+	Backend SegmentBackend
+
+	// Retention bounds how long sealed segments are kept around. The zero
+	// value disables retention entirely: nothing is ever removed except
+	// by an explicit Truncate call, matching the log's original behavior.
+	Retention Retention
+
+	Segment struct {
+		// MaxStoreBytes/MaxIndexBytes bound how large a single segment's
+		// store and index files may grow before Log rotates to a new
+		// active segment.
+		MaxStoreBytes uint64
+		MaxIndexBytes uint64
+
+		// InitialOffset is the offset the first segment starts at when a
+		// brand-new log is bootstrapped.
+		InitialOffset uint64
+
+		// SyncPolicy controls when Append's durability guarantee is
+		// satisfied relative to the underlying fsync. Defaults to
+		// SyncNone, matching the log's original no-explicit-fsync
+		// behavior.
+		SyncPolicy SyncPolicy
+
+		// ChecksumAlgo protects every record's store entry with a
+		// checksum that's verified on Read. Defaults to ChecksumNone,
+		// matching the store's original on-disk format.
+		ChecksumAlgo ChecksumAlgo
+
+		// Codec transparently compresses every record's store entry.
+		// nil (the default) disables compression, matching the store's
+		// original on-disk format. Mutually exclusive with ChecksumAlgo:
+		// a segment is created with either a checksummed store or a
+		// codec-compressed one, never both.
+		Codec Codec
+	}
+}
+
+// Retention bounds how much of the log's history is kept around. All
+// three limits are independent and additive: a segment is retired as soon
+// as any configured limit says it should be. A zero limit disables that
+// particular check.
+type Retention struct {
+	// MaxAge retires a segment once this long has passed since it was
+	// created (per segment.CreatedAt).
+	MaxAge time.Duration
+
+	// MaxBytes retires the oldest segments once the log's total store
+	// size exceeds this many bytes.
+	MaxBytes int64
+
+	// MaxSegments retires the oldest segments once the log has more than
+	// this many segments.
+	MaxSegments int
+
+	// CheckInterval is how often the retention loop re-evaluates the
+	// policy. Defaults to one minute when unset.
+	CheckInterval time.Duration
+}
+
+// enabled reports whether any retention limit is actually configured.
+func (r Retention) enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0 || r.MaxSegments > 0
+}
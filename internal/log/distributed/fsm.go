@@ -0,0 +1,139 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log"
+	"github.com/hashicorp/raft"
+	"google.golang.org/protobuf/proto"
+)
+
+// enc/lenWidth mirror the record framing internal/log's store package
+// writes ([lenWidth]uint64 length, then the record bytes), since Log.Reader
+// hands back the raw store bytes rather than decoded records.
+var enc = binary.BigEndian
+
+const lenWidth = 8
+
+// RequestType prefixes every entry Raft appends to its log, so Apply knows
+// how to decode the payload that follows.
+type RequestType byte
+
+const (
+	// AppendRequestType marks a raft log entry as a *api.ProduceRequest to
+	// be appended to the local log.
+	AppendRequestType RequestType = 0
+)
+
+// marshal encodes req as a length-prefixed protobuf message onto buf. It's
+// the counterpart to the request-type byte apply already wrote, so
+// fsm.Apply can read a self-describing raft log entry.
+func marshal(buf *bytes.Buffer, req interface{}) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return io.ErrUnexpectedEOF
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = buf.Write(b)
+	return err
+}
+
+// fsm applies committed Raft log entries to the local log. Every server in
+// the cluster runs an identical fsm over an identical sequence of entries,
+// which is what keeps their logs in sync.
+type fsm struct {
+	log *log.Log
+}
+
+var _ raft.FSM = (*fsm)(nil)
+
+// Apply is called by Raft once a log entry is committed by a quorum. The
+// first byte selects the request type; the rest is the marshaled request.
+func (f *fsm) Apply(record *raft.Log) interface{} {
+	buf := record.Data
+	reqType := RequestType(buf[0])
+	switch reqType {
+	case AppendRequestType:
+		return f.applyAppend(buf[1:])
+	}
+	return nil
+}
+
+func (f *fsm) applyAppend(b []byte) interface{} {
+	var req api.ProduceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+	offset, err := f.log.Append(req.Record)
+	if err != nil {
+		return err
+	}
+	return &api.ProduceResponse{Offset: offset}
+}
+
+// Snapshot captures the entire local log as of now, so Raft can compact its
+// own log and bring slow/new followers up to date without replaying every
+// entry from the beginning.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	r := f.log.Reader()
+	return &fsmSnapshot{reader: r}, nil
+}
+
+// Restore replaces the local log's contents with the records read from
+// snapshot, in the same [length][data] framing that Log.Reader produces
+// and store.Read expects.
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	if err := f.log.Reset(); err != nil {
+		return err
+	}
+
+	b := make([]byte, lenWidth)
+	var buf bytes.Buffer
+	for {
+		_, err := io.ReadFull(snapshot, b)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size := int64(enc.Uint64(b))
+		if _, err = io.CopyN(&buf, snapshot, size); err != nil {
+			return err
+		}
+
+		record := &api.Record{}
+		if err = proto.Unmarshal(buf.Bytes(), record); err != nil {
+			return err
+		}
+
+		if _, err = f.log.Append(record); err != nil {
+			return err
+		}
+		buf.Reset()
+	}
+	return nil
+}
+
+// fsmSnapshot streams the log captured by fsm.Snapshot to Raft's snapshot
+// sink, and discards it on Release since the underlying log.Reader holds
+// no resources that need closing beyond what the segments already own.
+type fsmSnapshot struct {
+	reader io.Reader
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := io.Copy(sink, s.reader); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
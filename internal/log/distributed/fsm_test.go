@@ -0,0 +1,88 @@
+package distributed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSMApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsm-apply-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lg, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	f := &fsm{log: lg}
+
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}}
+	var buf bytes.Buffer
+	require.NoError(t, buf.WriteByte(byte(AppendRequestType)))
+	require.NoError(t, marshal(&buf, req))
+
+	res := f.Apply(&raft.Log{Data: buf.Bytes()})
+	produceRes, ok := res.(*api.ProduceResponse)
+	require.True(t, ok)
+	require.Equal(t, uint64(0), produceRes.Offset)
+
+	read, err := lg.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), read.Value)
+}
+
+func TestFSMSnapshotRestore(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "fsm-snapshot-src-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+
+	srcLog, err := log.NewLog(srcDir, log.Config{})
+	require.NoError(t, err)
+	srcFSM := &fsm{log: srcLog}
+
+	for _, v := range [][]byte{[]byte("one"), []byte("two")} {
+		_, err := srcLog.Append(&api.Record{Value: v})
+		require.NoError(t, err)
+	}
+
+	snap, err := srcFSM.Snapshot()
+	require.NoError(t, err)
+
+	sink := &fakeSnapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+
+	dstDir, err := ioutil.TempDir("", "fsm-snapshot-dst-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dstDir)
+
+	dstLog, err := log.NewLog(dstDir, log.Config{})
+	require.NoError(t, err)
+	dstFSM := &fsm{log: dstLog}
+
+	require.NoError(t, dstFSM.Restore(ioutil.NopCloser(bytes.NewReader(sink.buf.Bytes()))))
+
+	read, err := dstLog.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), read.Value)
+
+	read, err = dstLog.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), read.Value)
+}
+
+// fakeSnapshotSink is a minimal raft.SnapshotSink that just captures the
+// bytes written to it, so fsmSnapshot.Persist can be tested without
+// standing up a real raft.Raft.
+type fakeSnapshotSink struct {
+	buf bytes.Buffer
+}
+
+func (s *fakeSnapshotSink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+func (s *fakeSnapshotSink) Close() error                { return nil }
+func (s *fakeSnapshotSink) Cancel() error               { return nil }
+func (s *fakeSnapshotSink) ID() string                  { return "fake" }
@@ -0,0 +1,292 @@
+// Package distributed wraps internal/log's segment/index/store log with
+// Raft consensus, so writes are replicated to a quorum of servers before
+// they're acknowledged instead of living on a single node.
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// ErrNotLeader is returned by Append when called on a follower. Only the
+// leader may accept writes; callers should retry against the leader
+// address returned by DistributedLog.Leader.
+var ErrNotLeader = errors.New("distributed log: not the leader")
+
+// Config configures a DistributedLog. Raft is the raft.Config to use;
+// BindAddr/DataDir mirror the plain Log's Config so the two can share a
+// bootstrap flow.
+type Config struct {
+	Raft struct {
+		raft.Config
+		StreamLayer *StreamLayer
+		Bootstrap   bool
+	}
+	DataDir string
+}
+
+// DistributedLog wraps a *log.Log with a Raft instance. Reads are served
+// directly from the local log; writes go through Raft.Apply so they're
+// replicated before Append returns.
+type DistributedLog struct {
+	config Config
+	log    *log.Log
+	raft   *raft.Raft
+}
+
+// NewDistributedLog opens the local log at dataDir/data and sets up Raft on
+// top of it.
+func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
+	dl := &DistributedLog{config: config}
+
+	if err := dl.setupLog(dataDir); err != nil {
+		return nil, err
+	}
+	if err := dl.setupRaft(dataDir); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (dl *DistributedLog) setupLog(dataDir string) error {
+	logDir := filepath.Join(dataDir, "log")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	var err error
+	dl.log, err = log.NewLog(logDir, log.Config{})
+	return err
+}
+
+// setupRaft wires the FSM, log/stable stores, and snapshot store used by
+// Raft to the local files under dataDir, then either bootstraps a new
+// single-node cluster or joins one already running.
+func (dl *DistributedLog) setupRaft(dataDir string) error {
+	fsm := &fsm{log: dl.log}
+
+	raftDir := filepath.Join(dataDir, "raft")
+	if err := os.MkdirAll(raftDir, 0755); err != nil {
+		return err
+	}
+
+	logStore, err := newLogStore(filepath.Join(raftDir, "log"))
+	if err != nil {
+		return err
+	}
+
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "stable"))
+	if err != nil {
+		return err
+	}
+
+	retain := 1
+	snapshotStore, err := raft.NewFileSnapshotStore(raftDir, retain, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	transport := raft.NewNetworkTransport(
+		dl.config.Raft.StreamLayer,
+		5,
+		10*time.Second,
+		os.Stderr,
+	)
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = dl.config.Raft.LocalID
+	if dl.config.Raft.HeartbeatTimeout != 0 {
+		raftConfig.HeartbeatTimeout = dl.config.Raft.HeartbeatTimeout
+	}
+	if dl.config.Raft.ElectionTimeout != 0 {
+		raftConfig.ElectionTimeout = dl.config.Raft.ElectionTimeout
+	}
+	if dl.config.Raft.LeaderLeaseTimeout != 0 {
+		raftConfig.LeaderLeaseTimeout = dl.config.Raft.LeaderLeaseTimeout
+	}
+	if dl.config.Raft.CommitTimeout != 0 {
+		raftConfig.CommitTimeout = dl.config.Raft.CommitTimeout
+	}
+
+	dl.raft, err = raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		return err
+	}
+
+	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
+	if err != nil {
+		return err
+	}
+	if dl.config.Raft.Bootstrap && !hasState {
+		cfg := raft.Configuration{
+			Servers: []raft.Server{{
+				ID:      raftConfig.LocalID,
+				Address: transport.LocalAddr(),
+			}},
+		}
+		return dl.raft.BootstrapCluster(cfg).Error()
+	}
+	return nil
+}
+
+// Append replicates record via Raft and returns the offset it was
+// committed at. It fails with ErrNotLeader on any node that isn't
+// currently the Raft leader.
+func (dl *DistributedLog) Append(record *api.Record) (uint64, error) {
+	res, err := dl.apply(AppendRequestType, &api.ProduceRequest{Record: record})
+	if err != nil {
+		return 0, err
+	}
+	return res.(*api.ProduceResponse).Offset, nil
+}
+
+// apply proposes req to the Raft cluster and waits for it to be committed
+// and applied to the local FSM.
+func (dl *DistributedLog) apply(reqType RequestType, req interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	if _, err := buf.Write([]byte{byte(reqType)}); err != nil {
+		return nil, err
+	}
+	if err := marshal(&buf, req); err != nil {
+		return nil, err
+	}
+
+	timeout := 10 * time.Second
+	future := dl.raft.Apply(buf.Bytes(), timeout)
+	if future.Error() != nil {
+		return nil, future.Error()
+	}
+
+	res := future.Response()
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Read reads the record at offset directly from the local log, without
+// going through Raft. Since all nodes apply the same committed log
+// entries in the same order, this is safe even on followers, though it
+// may lag the leader by however long replication takes.
+func (dl *DistributedLog) Read(offset uint64) (*api.Record, error) {
+	return dl.log.Read(offset)
+}
+
+// WaitForOffset blocks until the local log's highest offset reaches offset
+// or ctx is done, so a consumer following the tail (e.g. gRPC
+// ConsumeStream) sees records as they're applied instead of racing ahead
+// of Raft's replication. It's a pass-through to the local log, so it's
+// still subject to the read-may-lag-the-leader caveat on Read.
+func (dl *DistributedLog) WaitForOffset(ctx context.Context, offset uint64) error {
+	return dl.log.WaitForOffset(ctx, offset)
+}
+
+// Join adds the server identified by id, reachable at addr, to the Raft
+// cluster as a voter. Called on the leader; a follower returns
+// raft.ErrNotLeader.
+func (dl *DistributedLog) Join(id, addr string) error {
+	configFuture := dl.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+
+	serverID := raft.ServerID(id)
+	serverAddr := raft.ServerAddress(addr)
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID && srv.Address == serverAddr {
+			// already a member with this id and address
+			return nil
+		}
+		if srv.ID == serverID || srv.Address == serverAddr {
+			if err := dl.raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return fmt.Errorf("removing existing member: %w", err)
+			}
+		}
+	}
+
+	addFuture := dl.raft.AddVoter(serverID, serverAddr, 0, 0)
+	return addFuture.Error()
+}
+
+// Leave removes the server identified by id from the Raft cluster.
+func (dl *DistributedLog) Leave(id string) error {
+	return dl.raft.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+// WaitForLeader blocks until the cluster elects a leader or timeout
+// elapses, useful in tests that need a stable cluster before writing.
+func (dl *DistributedLog) WaitForLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if l := dl.raft.Leader(); l != "" {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("distributed log: timed out waiting for leader")
+}
+
+// Close shuts down Raft and the underlying log.
+func (dl *DistributedLog) Close() error {
+	f := dl.raft.Shutdown()
+	if err := f.Error(); err != nil {
+		return err
+	}
+	return dl.log.Close()
+}
+
+// StreamLayer multiplexes Raft's RPC traffic and proglog's own gRPC
+// traffic onto the same TCP listener, distinguishing them by a one-byte
+// prefix each connection sends before anything else. This lets a node
+// advertise a single address instead of two.
+type StreamLayer struct {
+	ln net.Listener
+}
+
+// RaftRPC is the byte a connection sends to identify itself as Raft RPC
+// traffic; anything else is assumed to be gRPC and left for the caller
+// (a cmux-style muxer in the process's main listener loop) to route.
+const RaftRPC = 1
+
+// NewStreamLayer wraps ln so Accept/Dial prefix each connection with
+// RaftRPC before handing it to Raft's transport.
+func NewStreamLayer(ln net.Listener) *StreamLayer {
+	return &StreamLayer{ln: ln}
+}
+
+func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(addr), timeout)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write([]byte{RaftRPC})
+	return conn, err
+}
+
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1)
+	if _, err := conn.Read(b); err != nil {
+		return nil, err
+	}
+	if b[0] != RaftRPC {
+		return nil, fmt.Errorf("distributed log: not a raft rpc")
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Close() error   { return s.ln.Close() }
+func (s *StreamLayer) Addr() net.Addr { return s.ln.Addr() }
@@ -0,0 +1,228 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log"
+	"github.com/hashicorp/raft"
+)
+
+// logStore adapts internal/log's Log to raft.LogStore by marshaling each
+// raft.Log into an api.Record (Value holds the raft.Log's own encoding)
+// and appending it through the ordinary segment/store/index path. It's a
+// thinner, append-only sibling of the data log the FSM writes to.
+//
+// Raft indices and the underlying Log's offsets are not the same number:
+// Raft's first real log index is always 1, and DeleteRange/snapshot
+// installs can make indices jump ahead of whatever offset the log would
+// have assigned next. So indexOffset tracks the index->offset mapping
+// explicitly instead of assuming Log.Append's own counter lines up with
+// raft.Log.Index.
+type logStore struct {
+	mu          sync.Mutex
+	log         *log.Log
+	indexOffset map[uint64]uint64
+	firstIndex  uint64
+	lastIndex   uint64
+}
+
+var _ raft.LogStore = (*logStore)(nil)
+
+func newLogStore(dir string) (*logStore, error) {
+	lg, err := log.NewLog(dir, log.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{log: lg, indexOffset: make(map[uint64]uint64)}, nil
+}
+
+func (s *logStore) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.firstIndex, nil
+}
+
+func (s *logStore) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastIndex, nil
+}
+
+func (s *logStore) GetLog(index uint64, out *raft.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, ok := s.indexOffset[index]
+	if !ok {
+		return raft.ErrLogNotFound
+	}
+	record, err := s.log.Read(offset)
+	if err != nil {
+		return raft.ErrLogNotFound
+	}
+	return decodeRaftLog(record.Value, out)
+}
+
+func (s *logStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *logStore) StoreLogs(logs []*raft.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range logs {
+		b, err := encodeRaftLog(l)
+		if err != nil {
+			return err
+		}
+		offset, err := s.log.Append(&api.Record{Value: b})
+		if err != nil {
+			return err
+		}
+		s.indexOffset[l.Index] = offset
+		if s.firstIndex == 0 || l.Index < s.firstIndex {
+			s.firstIndex = l.Index
+		}
+		if l.Index > s.lastIndex {
+			s.lastIndex = l.Index
+		}
+	}
+	return nil
+}
+
+// DeleteRange removes raft log entries with index in [min, max]. Raft calls
+// this in two situations: after installing a snapshot, to reclaim the
+// compacted prefix of its own log (min is the store's firstIndex), and
+// when a new leader's entries conflict with ours, to drop the suffix from
+// the conflicting index through our lastIndex (max is the store's
+// lastIndex). The underlying Log can only ever drop a prefix (Truncate
+// keeps everything above the given offset), so a prefix delete maps onto
+// it directly; a suffix delete instead goes through deleteSuffixLocked,
+// which rebuilds the Log from whatever entries still survive below min.
+func (s *logStore) DeleteRange(min, max uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if max >= s.lastIndex && min > s.firstIndex {
+		return s.deleteSuffixLocked(min)
+	}
+
+	var maxOffset uint64
+	found := false
+	for index, offset := range s.indexOffset {
+		if index < min || index > max {
+			continue
+		}
+		if !found || offset > maxOffset {
+			maxOffset = offset
+			found = true
+		}
+		delete(s.indexOffset, index)
+	}
+	if max >= s.firstIndex {
+		s.firstIndex = max + 1
+	}
+	if !found {
+		return nil
+	}
+	return s.log.Truncate(maxOffset)
+}
+
+// deleteSuffixLocked drops every entry with index >= min. Since Log has no
+// way to truncate anything but a prefix, it rebuilds the Log from scratch
+// (the same Reset-then-replay approach fsm.Restore uses for snapshots),
+// re-appending only the surviving entries (index < min) in index order.
+// Callers must hold s.mu.
+func (s *logStore) deleteSuffixLocked(min uint64) error {
+	type survivor struct {
+		index  uint64
+		record *api.Record
+	}
+	var survivors []survivor
+	for index, offset := range s.indexOffset {
+		if index >= min {
+			continue
+		}
+		record, err := s.log.Read(offset)
+		if err != nil {
+			return err
+		}
+		survivors = append(survivors, survivor{index, record})
+	}
+	sort.Slice(survivors, func(i, j int) bool { return survivors[i].index < survivors[j].index })
+
+	if err := s.log.Reset(); err != nil {
+		return err
+	}
+
+	s.indexOffset = make(map[uint64]uint64)
+	s.firstIndex = 0
+	s.lastIndex = 0
+	for _, sv := range survivors {
+		offset, err := s.log.Append(sv.record)
+		if err != nil {
+			return err
+		}
+		s.indexOffset[sv.index] = offset
+		if s.firstIndex == 0 || sv.index < s.firstIndex {
+			s.firstIndex = sv.index
+		}
+		if sv.index > s.lastIndex {
+			s.lastIndex = sv.index
+		}
+	}
+	return nil
+}
+
+// encodeRaftLog/decodeRaftLog give raft.Log a stable on-disk encoding.
+// Length-prefixed fields keep it simple to parse without pulling in gob or
+// a second protobuf message just for this.
+func encodeRaftLog(l *raft.Log) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, l.Index); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, l.Term); err != nil {
+		return nil, err
+	}
+	if err := buf.WriteByte(byte(l.Type)); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, uint64(len(l.Data))); err != nil {
+		return nil, err
+	}
+	if _, err := buf.Write(l.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRaftLog(b []byte, out *raft.Log) error {
+	buf := bytes.NewReader(b)
+	if err := binary.Read(buf, binary.BigEndian, &out.Index); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &out.Term); err != nil {
+		return err
+	}
+	typ, err := buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	out.Type = raft.LogType(typ)
+
+	var dataLen uint64
+	if err := binary.Read(buf, binary.BigEndian, &dataLen); err != nil {
+		return err
+	}
+	out.Data = make([]byte, dataLen)
+	if _, err := buf.Read(out.Data); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// syncMode selects how a SyncPolicy decides when to fsync.
+type syncMode int
+
+const (
+	syncNone syncMode = iota
+	syncEveryWrite
+	syncInterval
+	syncBatch
+)
+
+// SyncPolicy controls when Log.Append's durability guarantee is satisfied
+// relative to the fsync of the active segment's store and index.
+type SyncPolicy struct {
+	mode      syncMode
+	interval  time.Duration
+	batchSize int
+}
+
+// SyncNone never fsyncs on Append's behalf; data durability is left to the
+// OS's own page cache writeback. This is the zero value, so it's also
+// what an unconfigured Config gets.
+var SyncNone = SyncPolicy{mode: syncNone}
+
+// SyncEveryWrite fsyncs after every single Append before returning to the
+// caller. Safest, slowest.
+var SyncEveryWrite = SyncPolicy{mode: syncEveryWrite}
+
+// SyncInterval fsyncs at most once every d, batching however many Appends
+// land within that window into a single fsync (group commit).
+func SyncInterval(d time.Duration) SyncPolicy {
+	return SyncPolicy{mode: syncInterval, interval: d}
+}
+
+// SyncBatch fsyncs once n Appends are waiting, or the flusher is closed,
+// whichever comes first.
+func SyncBatch(n int) SyncPolicy {
+	return SyncPolicy{mode: syncBatch, batchSize: n}
+}
+
+// DurabilityStats reports observability data for the flusher's fsync
+// batching, so operators can see whether their SyncPolicy is actually
+// grouping writes.
+type DurabilityStats struct {
+	Fsyncs       uint64
+	FsyncLatency time.Duration // most recent fsync's wall time
+	LastBatch    int           // number of Appends satisfied by the most recent fsync
+}
+
+// flusher implements group commit: appenders call wait() and block until
+// the flusher's next fsync of the active segment completes, so N
+// concurrent appenders that land in the same batch pay for one fsync
+// between them instead of one each.
+type flusher struct {
+	lg     *Log
+	policy SyncPolicy
+
+	mu      sync.Mutex
+	waiters []chan error
+	stats   DurabilityStats
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	done      chan struct{}
+}
+
+func newFlusher(lg *Log, policy SyncPolicy) *flusher {
+	f := &flusher{
+		lg:      lg,
+		policy:  policy,
+		closeCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if policy.mode == syncInterval {
+		go f.intervalLoop()
+	} else {
+		close(f.done)
+	}
+	return f
+}
+
+// wait enqueues the calling Append and blocks until its durability
+// requirement is met. SyncNone is a no-op: Append already returned once
+// the write hit the store/index, matching the log's original behavior.
+func (f *flusher) wait() error {
+	switch f.policy.mode {
+	case syncNone:
+		return nil
+	case syncEveryWrite:
+		return f.flushNow()
+	default: // syncInterval, syncBatch
+		return f.enqueue()
+	}
+}
+
+// enqueue registers the caller in the current batch and, for SyncBatch,
+// triggers an immediate flush once the batch is full. SyncInterval batches
+// flush on the intervalLoop's ticker instead.
+func (f *flusher) enqueue() error {
+	done := make(chan error, 1)
+
+	f.mu.Lock()
+	f.waiters = append(f.waiters, done)
+	shouldFlush := f.policy.mode == syncBatch && len(f.waiters) >= f.policy.batchSize
+	f.mu.Unlock()
+
+	if shouldFlush {
+		f.flushBatch()
+	}
+	return <-done
+}
+
+// flushNow fsyncs immediately and blocks the single caller on it; used by
+// SyncEveryWrite where there's nothing to batch.
+func (f *flusher) flushNow() error {
+	start := time.Now()
+	err := f.lg.syncActiveSegment()
+	f.recordFlush(start, 1)
+	return err
+}
+
+// flushBatch fsyncs once on behalf of every waiter enqueued so far and
+// releases them all with the same result — the group commit.
+func (f *flusher) flushBatch() {
+	f.mu.Lock()
+	waiters := f.waiters
+	f.waiters = nil
+	f.mu.Unlock()
+
+	if len(waiters) == 0 {
+		return
+	}
+
+	start := time.Now()
+	err := f.lg.syncActiveSegment()
+	f.recordFlush(start, len(waiters))
+
+	for _, w := range waiters {
+		w <- err
+	}
+}
+
+func (f *flusher) recordFlush(start time.Time, batchSize int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.Fsyncs++
+	f.stats.FsyncLatency = time.Since(start)
+	f.stats.LastBatch = batchSize
+}
+
+func (f *flusher) intervalLoop() {
+	defer close(f.done)
+	ticker := time.NewTicker(f.policy.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.flushBatch()
+		case <-f.closeCh:
+			f.flushBatch() // drain outstanding waiters before exiting
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of the flusher's fsync observability data.
+func (f *flusher) Stats() DurabilityStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// Close stops the interval loop (if any), flushes any batch still
+// outstanding so no appender is left blocked forever, and waits for the
+// loop goroutine to exit.
+func (f *flusher) Close() {
+	f.closeOnce.Do(func() {
+		close(f.closeCh)
+	})
+	<-f.done
+	f.flushBatch() // catch anyone who enqueued after the loop's last drain
+}
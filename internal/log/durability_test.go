@@ -0,0 +1,77 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurabilitySyncEveryWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durability-every-write-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.SyncPolicy = SyncEveryWrite
+	lg, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer lg.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := lg.Append(&api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+	}
+
+	stats := lg.DurabilityStats()
+	require.Equal(t, uint64(3), stats.Fsyncs)
+	require.Equal(t, 1, stats.LastBatch)
+}
+
+// TestDurabilitySyncBatch confirms concurrent Appends that land in the
+// same batch share a single fsync between them (group commit), rather
+// than each paying for its own.
+func TestDurabilitySyncBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durability-batch-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.SyncPolicy = SyncBatch(3)
+	lg, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer lg.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := lg.Append(&api.Record{Value: []byte("hello")})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	stats := lg.DurabilityStats()
+	require.Equal(t, uint64(1), stats.Fsyncs)
+	require.Equal(t, 3, stats.LastBatch)
+}
+
+func TestDurabilityStatsZeroValueWhenDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durability-none-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lg, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer lg.Close()
+
+	_, err = lg.Append(&api.Record{Value: []byte("hello")})
+	require.NoError(t, err)
+
+	require.Equal(t, DurabilityStats{}, lg.DurabilityStats())
+}
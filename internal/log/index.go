@@ -50,7 +50,10 @@ func newIndex(f *os.File, c Config) (*index, error) {
 		file: f,
 	}
 
-	fi, err := os.Stat(f.Name())
+	// stat and grow the fd rather than the path: a backend (e.g.
+	// MemoryBackend) may have already unlinked the path, in which case
+	// only the fd is still good for anything
+	fi, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
@@ -60,9 +63,7 @@ func newIndex(f *os.File, c Config) (*index, error) {
 	idx.size = uint64(fi.Size())
 
 	// grow the file to the max index size
-	if err = os.Truncate(
-		f.Name(), int64(c.Segment.MaxIndexBytes),
-	); err != nil {
+	if err = f.Truncate(int64(c.Segment.MaxIndexBytes)); err != nil {
 		return nil, err
 	}
 
@@ -78,6 +79,16 @@ func newIndex(f *os.File, c Config) (*index, error) {
 	return idx, nil
 }
 
+// Sync flushes the memory-mapped entries and fsyncs the backing file
+// without truncating or closing it, so the durability flusher can fsync
+// the active segment's index while it's still being written to.
+func (i *index) Sync() error {
+	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+		return err
+	}
+	return i.file.Sync()
+}
+
 func (i *index) Close() error {
 
 	// sure the memory-mapped file has synced its data to the persisted file
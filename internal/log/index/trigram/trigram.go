@@ -0,0 +1,166 @@
+// Package trigram builds a per-segment inverted index over 3-byte
+// substrings ("trigrams") of record values, so Log.Search can find
+// candidate offsets without scanning every record.
+package trigram
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"sort"
+
+	"github.com/tysonmote/gommap"
+)
+
+// Trigram is a trigram: three consecutive bytes of a record's value.
+type Trigram [3]byte
+
+// Index is one segment's posting list: for each trigram, the sorted,
+// deduplicated offsets of records in the segment whose value contains it.
+type Index struct {
+	path     string
+	postings map[Trigram][]uint64
+}
+
+// New creates an empty index for a segment that has no ".tri" file yet.
+func New(path string) *Index {
+	return &Index{
+		path:     path,
+		postings: make(map[Trigram][]uint64),
+	}
+}
+
+// Open loads an existing ".tri" file written by a prior Persist. The file
+// is memory-mapped and decoded once into postings; callers pay the mmap
+// and page-fault cost up front rather than on every Lookup.
+func Open(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return New(path), nil
+	}
+
+	mmap, err := gommap.Map(f.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	defer mmap.UnsafeUnmap()
+
+	idx := New(path)
+	if err := idx.decode(mmap); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Add extracts every overlapping trigram from value and records offset
+// against each one, skipping trigrams that would cross a record boundary
+// (there's no such thing here — value is a single record, so every
+// trigram is entirely within it by construction).
+func (idx *Index) Add(offset uint64, value []byte) {
+	for _, tri := range Trigrams(value) {
+		postings := idx.postings[tri]
+		if n := len(postings); n > 0 && postings[n-1] == offset {
+			continue
+		}
+		idx.postings[tri] = append(postings, offset)
+	}
+}
+
+// Trigrams returns every overlapping 3-byte window of b. Values shorter
+// than 3 bytes contain no trigrams at all.
+func Trigrams(b []byte) []Trigram {
+	if len(b) < 3 {
+		return nil
+	}
+	out := make([]Trigram, 0, len(b)-2)
+	for i := 0; i+3 <= len(b); i++ {
+		out = append(out, Trigram{b[i], b[i+1], b[i+2]})
+	}
+	return out
+}
+
+// Lookup returns the sorted offsets of records known to contain tri.
+// Trigrams over-match (a false positive is any offset whose record
+// doesn't actually contain the queried substring at a byte boundary
+// matching the query) so callers must verify candidates against the
+// actual record.
+func (idx *Index) Lookup(tri Trigram) []uint64 {
+	return idx.postings[tri]
+}
+
+// Persist writes the index to its ".tri" file as
+// [trigram(3 bytes)][count varint][offsets, varint-delta-encoded]
+// repeated for every trigram, in ascending trigram order so Open's decode
+// is deterministic.
+func (idx *Index) Persist() error {
+	f, err := os.OpenFile(idx.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	tris := make([]Trigram, 0, len(idx.postings))
+	for tri := range idx.postings {
+		tris = append(tris, tri)
+	}
+	sort.Slice(tris, func(i, j int) bool {
+		return string(tris[i][:]) < string(tris[j][:])
+	})
+
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	for _, tri := range tris {
+		if _, err := w.Write(tri[:]); err != nil {
+			return err
+		}
+		offsets := idx.postings[tri]
+		n := binary.PutUvarint(varintBuf, uint64(len(offsets)))
+		if _, err := w.Write(varintBuf[:n]); err != nil {
+			return err
+		}
+		var prev uint64
+		for _, off := range offsets {
+			n := binary.PutUvarint(varintBuf, off-prev)
+			if _, err := w.Write(varintBuf[:n]); err != nil {
+				return err
+			}
+			prev = off
+		}
+	}
+	return w.Flush()
+}
+
+// decode parses the format Persist writes out of an in-memory buffer
+// (typically the segment's memory-mapped ".tri" file).
+func (idx *Index) decode(b []byte) error {
+	pos := 0
+	for pos < len(b) {
+		var tri Trigram
+		copy(tri[:], b[pos:pos+3])
+		pos += 3
+
+		count, n := binary.Uvarint(b[pos:])
+		pos += n
+
+		offsets := make([]uint64, 0, count)
+		var prev uint64
+		for i := uint64(0); i < count; i++ {
+			delta, n := binary.Uvarint(b[pos:])
+			pos += n
+			prev += delta
+			offsets = append(offsets, prev)
+		}
+		idx.postings[tri] = offsets
+	}
+	return nil
+}
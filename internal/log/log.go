@@ -1,17 +1,16 @@
 package log
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"path"
 	"sort"
-	"strconv"
-	"strings"
 	"sync"
 
 	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log/index/trigram"
 )
 
 // Log manages the list of segments
@@ -26,6 +25,26 @@ type Log struct {
 	activeSegment *segment
 	// a list of segments
 	segments []*segment
+
+	// backend decides where segments' store/index files actually live.
+	// Defaults to a LocalBackend rooted at Dir.
+	backend SegmentBackend
+
+	// cond is broadcast every time Append commits a record, so callers
+	// following the tail of the log (e.g. the gRPC ConsumeStream) can
+	// block until new data arrives instead of polling Read
+	cond *sync.Cond
+
+	// durability batches fsyncs of the active segment according to
+	// Config.Segment.SyncPolicy. nil when the policy is SyncNone.
+	durability *flusher
+
+	// retention{Events,Stop,Done} back the background retentionLoop
+	// started by startRetentionLoop when Config.Retention is configured;
+	// all three stay nil otherwise.
+	retentionEvents chan RetentionEvent
+	retentionStop   chan struct{}
+	retentionDone   chan struct{}
 }
 
 // NewLog sets the default for the configs, if not specified, and sets up a log instance
@@ -40,45 +59,36 @@ func NewLog(dir string, c Config) (*Log, error) {
 		Dir:    dir,
 		Config: c,
 	}
+	lg.backend = c.Backend
+	if lg.backend == nil {
+		lg.backend = NewLocalBackend(dir)
+	}
+	lg.cond = sync.NewCond(&lg.mu)
+	if c.Segment.SyncPolicy.mode != syncNone {
+		lg.durability = newFlusher(lg, c.Segment.SyncPolicy)
+	}
 
-	return lg, lg.setup()
+	if err := lg.setup(); err != nil {
+		return nil, err
+	}
+	lg.startRetentionLoop()
+	return lg, nil
 }
 
 // setup a log instance
 func (lg *Log) setup() error {
 	// on startup, the log is responsible for setting itself up for the
-	//	segments that already exist on disk or, if the log is new and
-	//	has no existing segments, for bootstrapping the initial segment.
-
-	// read the segment files
-	files, err := ioutil.ReadDir(lg.Dir)
+	//	segments that already exist (per the backend), or, if the log is
+	//	new and has no existing segments, for bootstrapping the initial
+	//	segment.
+	baseOffsets, err := lg.backend.ListBaseOffsets()
 	if err != nil {
 		return err
 	}
 
-	// parse the base offsets info from the name of each segment
-	var baseOffsets []uint64
-	for _, file := range files {
-		offsetString := strings.TrimSuffix(
-			file.Name(),
-			path.Ext(file.Name()),
-		)
-
-		off, _ := strconv.ParseUint(offsetString, 10, 0)
-		baseOffsets = append(baseOffsets, off)
-	}
-
-	// sort the base offsets in ascending order (e.g. segments are
-	//	in order from oldest to newest)
-	sort.Slice(baseOffsets, func(i, j int) bool {
-		return baseOffsets[i] < baseOffsets[j]
-	})
-
 	// create the segments with newSegment() helper function
-	// baseOffsets contains an information for index and store,
-	//	so increment i by two to skip over the duplicate information
-	for i := 0; i < len(baseOffsets); i += 2 {
-		if err = lg.newSegment(baseOffsets[i]); err != nil {
+	for _, off := range baseOffsets {
+		if err = lg.newSegment(off); err != nil {
 			return err
 		}
 	}
@@ -94,6 +104,27 @@ func (lg *Log) setup() error {
 
 // Append appends a record to the log
 func (lg *Log) Append(record *api.Record) (uint64, error) {
+	offset, err := lg.appendLocked(record)
+	if err != nil {
+		return 0, err
+	}
+
+	// Satisfy the record's durability requirement, if any, outside of
+	// lg.mu: the flusher's fsync (and the batch it may be waiting to
+	// fill) must not hold the log lock, or concurrent appenders sharing
+	// this batch would deadlock against each other.
+	if lg.durability != nil {
+		if err := lg.durability.wait(); err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// appendLocked does the actual append under lg.mu and returns as soon as
+// the record has been written to the active segment's store and index,
+// before any durability policy's fsync.
+func (lg *Log) appendLocked(record *api.Record) (uint64, error) {
 	lg.mu.Lock()
 	defer lg.mu.Unlock()
 
@@ -105,11 +136,50 @@ func (lg *Log) Append(record *api.Record) (uint64, error) {
 
 	// if the segment is at its max size, create a new active segment
 	if lg.activeSegment.IsMaxed() {
-		err = lg.newSegment(offset + 1)
+		sealed := lg.activeSegment
+		if err = lg.newSegment(offset + 1); err != nil {
+			return offset, err
+		}
+		// Persist the outgoing segment's trigram posting list now rather
+		// than waiting for Log.Close, so a crash before a graceful
+		// shutdown doesn't silently lose Search coverage for every
+		// already-rotated segment.
+		if err = sealed.tri.Persist(); err != nil {
+			return offset, err
+		}
+		// Give the backend a chance to tier the now-immutable segment off
+		// to wherever cold data belongs (e.g. S3Backend uploads it and
+		// evicts the local cache). A no-op for Local/Memory.
+		err = lg.backend.Seal(sealed.baseOffset)
 	}
+
+	// wake up any callers blocked in WaitForOffset waiting for this record
+	lg.cond.Broadcast()
 	return offset, err
 }
 
+// syncActiveSegment fsyncs whichever segment is currently active, on
+// behalf of the durability flusher. A record whose segment has since
+// rotated out from under it is fsynced by the next batch instead; the
+// window between an append and its rotation is small enough in practice
+// that this hasn't warranted tracking per-record segment fsyncs.
+func (lg *Log) syncActiveSegment() error {
+	lg.mu.Lock()
+	seg := lg.activeSegment
+	lg.mu.Unlock()
+	return seg.Sync()
+}
+
+// DurabilityStats reports the flusher's fsync latency and batch size
+// observability data. It returns the zero value when Config.Segment.SyncPolicy
+// is SyncNone, since there's no flusher to report on.
+func (lg *Log) DurabilityStats() DurabilityStats {
+	if lg.durability == nil {
+		return DurabilityStats{}
+	}
+	return lg.durability.Stats()
+}
+
 // Read reads the record stored at the given offset
 func (lg *Log) Read(offset uint64) (*api.Record, error) {
 	lg.mu.Lock()
@@ -132,8 +202,108 @@ func (lg *Log) Read(offset uint64) (*api.Record, error) {
 	return s.Read(offset)
 }
 
+// Search returns the offsets of records whose value contains query,
+// ascending. Queries of 3 bytes or more use each segment's trigram
+// posting list to narrow candidates; shorter queries have no trigrams to
+// look up, so Search falls back to scanning every record.
+func (lg *Log) Search(query []byte) ([]uint64, error) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	if len(query) < 3 {
+		return lg.scanLocked(query)
+	}
+
+	tris := trigram.Trigrams(query)
+	var matches []uint64
+	for _, s := range lg.segments {
+		if s.tri == nil {
+			continue
+		}
+		for _, offset := range intersectPostings(s.tri, tris) {
+			record, err := s.Read(offset)
+			if err != nil {
+				return nil, err
+			}
+			// trigrams over-match short queries and byte boundaries, so
+			// verify each candidate against the real record before
+			// trusting it
+			if bytes.Contains(record.Value, query) {
+				matches = append(matches, offset)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+	return matches, nil
+}
+
+// scanLocked checks every record in the log for query, for queries too
+// short to have any trigrams. Callers must hold lg.mu.
+func (lg *Log) scanLocked(query []byte) ([]uint64, error) {
+	var matches []uint64
+	for _, s := range lg.segments {
+		for offset := s.baseOffset; offset < s.nextOffset; offset++ {
+			record, err := s.Read(offset)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Contains(record.Value, query) {
+				matches = append(matches, offset)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// intersectPostings returns the offsets present in every trigram's posting
+// list within a single segment, i.e. the offsets of records that could
+// possibly contain the full query.
+func intersectPostings(idx *trigram.Index, tris []trigram.Trigram) []uint64 {
+	if len(tris) == 0 {
+		return nil
+	}
+
+	result := idx.Lookup(tris[0])
+	for _, tri := range tris[1:] {
+		if len(result) == 0 {
+			return nil
+		}
+		result = intersectSorted(result, idx.Lookup(tri))
+	}
+	return result
+}
+
+// intersectSorted returns the elements common to two ascending, deduped
+// slices in O(len(a)+len(b)).
+func intersectSorted(a, b []uint64) []uint64 {
+	var out []uint64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
 // Close iterates over the segments and closes them
 func (lg *Log) Close() error {
+	lg.stopRetentionLoop()
+
+	if lg.durability != nil {
+		// release any appenders still waiting on a batch before closing
+		// the segments out from under them
+		lg.durability.Close()
+	}
+
 	lg.mu.Lock()
 	defer lg.mu.Unlock()
 
@@ -159,7 +329,11 @@ func (lg *Log) Reset() error {
 	if err := lg.Remove(); err != nil {
 		return err
 	}
-	return lg.setup()
+	if err := lg.setup(); err != nil {
+		return err
+	}
+	lg.startRetentionLoop()
+	return nil
 }
 
 // LowestOffset returns the lower bound of the offset range of the log
@@ -173,12 +347,45 @@ func (lg *Log) LowestOffset() (uint64, error) {
 func (lg *Log) HighestOffset() (uint64, error) {
 	lg.mu.Lock()
 	defer lg.mu.Unlock()
+	return lg.highestOffsetLocked(), nil
+}
+
+// highestOffsetLocked returns the log's highest offset. Callers must hold lg.mu.
+func (lg *Log) highestOffsetLocked() uint64 {
 	offset := lg.segments[len(lg.segments)-1].nextOffset
 	if offset == 0 {
-		return 0, nil
+		return 0
 	}
+	return offset - 1
+}
 
-	return offset - 1, nil
+// WaitForOffset blocks until the log's highest offset reaches the given
+// offset or ctx is done, whichever happens first. It lets a caller follow
+// the tail of the log (e.g. a gRPC ConsumeStream) instead of repeatedly
+// calling Read and handling "offset out of range" errors.
+func (lg *Log) WaitForOffset(ctx context.Context, offset uint64) error {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	// Cond.Wait has no notion of a context, so wake it up ourselves once
+	// ctx is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			lg.cond.Broadcast()
+		case <-stop:
+		}
+	}()
+
+	for lg.highestOffsetLocked() < offset {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lg.cond.Wait()
+	}
+	return nil
 }
 
 // Truncate removes all segments whose highest offset is lower than lowest
@@ -235,7 +442,7 @@ func (o *originReader) Read(bytes []byte) (int, error) {
 // segments, and makes the new segment the active one so that subsequent
 // append calls write to it
 func (lg *Log) newSegment(offset uint64) error {
-	seg, err := newSegment(lg.Dir, offset, lg.Config)
+	seg, err := newSegment(lg.backend, offset, lg.Config)
 	if err != nil {
 		return err
 	}
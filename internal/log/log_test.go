@@ -19,6 +19,7 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"search":                            testSearch,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "store-test")
@@ -135,3 +136,49 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+// testSearch tests that Search finds records via a segment's trigram
+// posting list, falls back to a full scan for queries too short to have
+// any trigrams, and keeps working after a restart, since rotating a
+// segment out persists its posting list rather than dropping it.
+func testSearch(t *testing.T, log *Log) {
+	values := []string{
+		"the quick brown fox",
+		"jumps over the lazy dog",
+		"an unrelated third line",
+	}
+	for _, v := range values {
+		_, err := log.Append(&api.Record{Value: []byte(v)})
+		require.NoError(t, err)
+	}
+	// MaxStoreBytes is small enough in this table's Config that appending
+	// three records above forces at least one rotation, spreading matches
+	// across more than one segment.
+	require.Greater(t, len(log.segments), 1)
+
+	matches, err := log.Search([]byte("fox"))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, matches)
+
+	matches, err = log.Search([]byte("the"))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, matches)
+
+	// a query under 3 bytes has no trigrams to look up, so Search falls
+	// back to scanning every record instead
+	matches, err = log.Search([]byte("fo"))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, matches)
+
+	matches, err = log.Search([]byte("zzz"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	require.NoError(t, log.Close())
+	nLog, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+
+	matches, err = nLog.Search([]byte("fox"))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, matches)
+}
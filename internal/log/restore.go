@@ -0,0 +1,226 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Range is a half-open byte range [Start, End) within a store's file.
+type Range struct {
+	Start uint64
+	End   uint64
+}
+
+func (r Range) overlaps(other Range) bool {
+	return r.Start < other.End && other.Start < r.End
+}
+
+// ErrRangeIncomplete is returned by Read/ReadAt when a store created with
+// newStoreForRestore hasn't yet had all the bytes a read needs written to
+// it. Missing is the specific sub-range the caller should fetch (e.g. from
+// a peer) and retry with AppendAt.
+type ErrRangeIncomplete struct {
+	Missing Range
+}
+
+func (e *ErrRangeIncomplete) Error() string {
+	return fmt.Sprintf("store: range [%d, %d) not yet written", e.Missing.Start, e.Missing.End)
+}
+
+// restoreState tracks which byte ranges of a store have been written when
+// the store is in restore mode, where records can arrive out of order and
+// from multiple peers concurrently (e.g. replicating a segment in
+// parallel). It range-locks AppendAt calls against each other so writes to
+// non-overlapping ranges can proceed concurrently, while writes to
+// overlapping ranges wait their turn.
+type restoreState struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// size is the store's expected final size, known up front (e.g. from
+	// the peer's segment metadata) even though the bytes themselves
+	// arrive piecemeal.
+	size uint64
+
+	// covered holds the sorted, merged, non-overlapping ranges that have
+	// been fully written and are safe to read.
+	covered []Range
+
+	// inflight holds the ranges currently being written by an in-progress
+	// AppendAt call, so a second AppendAt for an overlapping range waits
+	// instead of racing it.
+	inflight []Range
+}
+
+func newRestoreState(size uint64) *restoreState {
+	rs := &restoreState{size: size}
+	rs.cond = sync.NewCond(&rs.mu)
+	return rs
+}
+
+// lockRange blocks until rng doesn't overlap any in-flight write, then
+// reserves it as in-flight.
+func (rs *restoreState) lockRange(rng Range) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for rs.overlapsInflightLocked(rng) {
+		rs.cond.Wait()
+	}
+	rs.inflight = append(rs.inflight, rng)
+}
+
+// unlockRange releases rng's in-flight reservation and records it as
+// covered, waking any AppendAt calls waiting on an overlapping range.
+func (rs *restoreState) unlockRange(rng Range) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i, r := range rs.inflight {
+		if r == rng {
+			rs.inflight = append(rs.inflight[:i], rs.inflight[i+1:]...)
+			break
+		}
+	}
+	rs.covered = mergeRange(rs.covered, rng)
+	rs.cond.Broadcast()
+}
+
+func (rs *restoreState) overlapsInflightLocked(rng Range) bool {
+	for _, r := range rs.inflight {
+		if rng.overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstGapInRange returns the first sub-range of rng that hasn't been
+// covered yet, and false if rng is already fully covered.
+func (rs *restoreState) firstGapInRange(rng Range) (Range, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	cursor := rng.Start
+	for _, r := range rs.covered {
+		if cursor >= rng.End {
+			break
+		}
+		if r.Start > cursor {
+			gapEnd := r.Start
+			if gapEnd > rng.End {
+				gapEnd = rng.End
+			}
+			return Range{Start: cursor, End: gapEnd}, true
+		}
+		if r.End > cursor {
+			cursor = r.End
+		}
+	}
+	if cursor < rng.End {
+		return Range{Start: cursor, End: rng.End}, true
+	}
+	return Range{}, false
+}
+
+// missingRanges returns the gaps between [0, size) not yet covered.
+func (rs *restoreState) missingRanges() []Range {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var missing []Range
+	var cursor uint64
+	for _, r := range rs.covered {
+		if r.Start > cursor {
+			missing = append(missing, Range{Start: cursor, End: r.Start})
+		}
+		if r.End > cursor {
+			cursor = r.End
+		}
+	}
+	if cursor < rs.size {
+		missing = append(missing, Range{Start: cursor, End: rs.size})
+	}
+	return missing
+}
+
+// mergeRange inserts rng into ranges, keeping the result sorted and with
+// any overlapping or touching ranges merged together.
+func mergeRange(ranges []Range, rng Range) []Range {
+	ranges = append(ranges, rng)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if len(merged) > 0 && r.Start <= merged[len(merged)-1].End {
+			if r.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// newStoreForRestore creates a store in restore mode: size is the store's
+// known final size, but its bytes are expected to arrive out of order via
+// AppendAt rather than sequentially via Append, e.g. while replicating a
+// segment from several peers in parallel. MissingRanges reports what's
+// left to fetch, and Read refuses to serve a record until every byte it
+// needs has arrived.
+func newStoreForRestore(f *os.File, size uint64) (*store, error) {
+	s, err := newStore(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	s.size = size
+	s.restore = newRestoreState(size)
+	return s, nil
+}
+
+// AppendAt writes data at the caller-specified byte offset pos, for
+// restoring a store out of order. It range-locks against other AppendAt
+// calls so only genuinely overlapping writes block each other.
+func (s *store) AppendAt(pos uint64, data []byte) error {
+	if s.restore == nil {
+		return fmt.Errorf("store: AppendAt requires a store created with newStoreForRestore")
+	}
+
+	rng := Range{Start: pos, End: pos + uint64(len(data))}
+	if rng.End > s.restore.size {
+		return fmt.Errorf("store: range [%d, %d) exceeds store size %d", rng.Start, rng.End, s.restore.size)
+	}
+
+	s.restore.lockRange(rng)
+	defer s.restore.unlockRange(rng)
+
+	_, err := s.File.WriteAt(data, int64(pos))
+	return err
+}
+
+// MissingRanges reports the gaps still needed to make a restore-mode
+// store's data complete, so the caller can drive re-fetches for exactly
+// those sub-ranges.
+func (s *store) MissingRanges() ([]Range, error) {
+	if s.restore == nil {
+		return nil, fmt.Errorf("store: MissingRanges requires a store created with newStoreForRestore")
+	}
+	return s.restore.missingRanges(), nil
+}
+
+// checkRangeCoveredLocked returns ErrRangeIncomplete naming the first gap
+// in rng if s is in restore mode and rng isn't fully covered yet. A no-op
+// for a store not in restore mode. Callers must hold s.mu.
+func (s *store) checkRangeCoveredLocked(rng Range) error {
+	if s.restore == nil {
+		return nil
+	}
+	if gap, incomplete := s.restore.firstGapInRange(rng); incomplete {
+		return &ErrRangeIncomplete{Missing: gap}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package log
+
+import "time"
+
+// RetentionEvent describes a segment the retention loop removed, so
+// callers (metrics, audit logs) can observe deletions instead of only
+// noticing that old data is gone.
+type RetentionEvent struct {
+	BaseOffset uint64
+	Reason     string
+	RemovedAt  time.Time
+}
+
+// startRetentionLoop starts the background goroutine that evaluates
+// Config.Retention against the log's segments. It's a no-op (no goroutine
+// started) when Retention is unconfigured, matching the log's original
+// behavior of only ever removing segments via an explicit Truncate.
+func (lg *Log) startRetentionLoop() {
+	if !lg.Config.Retention.enabled() {
+		return
+	}
+
+	lg.retentionEvents = make(chan RetentionEvent, 16)
+	lg.retentionStop = make(chan struct{})
+	lg.retentionDone = make(chan struct{})
+
+	interval := lg.Config.Retention.CheckInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	go lg.retentionLoop(interval)
+}
+
+func (lg *Log) retentionLoop(interval time.Duration) {
+	defer close(lg.retentionDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lg.applyRetention()
+		case <-lg.retentionStop:
+			return
+		}
+	}
+}
+
+// applyRetention retires whichever oldest segments the configured policy
+// says are past their welcome. It holds lg.mu only long enough to decide
+// which segments to drop and remove them from lg.segments; the actual
+// file removal and event emission happen unlocked, so a slow disk doesn't
+// stall concurrent Appends/Reads.
+func (lg *Log) applyRetention() {
+	lg.mu.Lock()
+	toRemove := lg.segmentsToRetireLocked()
+	if len(toRemove) > 0 {
+		lg.segments = lg.segments[len(toRemove):]
+	}
+	lg.mu.Unlock()
+
+	for _, s := range toRemove {
+		reason := lg.retirementReason(s)
+		if err := s.Remove(); err != nil {
+			continue
+		}
+		lg.emitRetentionEvent(RetentionEvent{
+			BaseOffset: s.baseOffset,
+			Reason:     reason,
+			RemovedAt:  time.Now(),
+		})
+	}
+}
+
+// segmentsToRetireLocked returns the prefix of lg.segments (oldest first)
+// that some part of the retention policy says to drop. The active
+// (last) segment is never a candidate, since it's still being written to.
+// Callers must hold lg.mu.
+func (lg *Log) segmentsToRetireLocked() []*segment {
+	if len(lg.segments) <= 1 {
+		return nil
+	}
+	candidates := lg.segments[:len(lg.segments)-1]
+	r := lg.Config.Retention
+	now := time.Now()
+
+	cut := 0
+	for i, s := range candidates {
+		expired := r.MaxAge > 0 && now.Sub(s.CreatedAt()) > r.MaxAge
+		tooManySegments := r.MaxSegments > 0 && len(lg.segments)-i > r.MaxSegments
+		if expired || tooManySegments {
+			cut = i + 1
+		}
+	}
+
+	if r.MaxBytes > 0 {
+		total := lg.totalStoreBytesLocked()
+		for i := 0; i < len(candidates) && total > r.MaxBytes; i++ {
+			total -= int64(candidates[i].store.size)
+			if i+1 > cut {
+				cut = i + 1
+			}
+		}
+	}
+
+	return candidates[:cut]
+}
+
+// totalStoreBytesLocked sums the on-disk store size of every segment.
+// Callers must hold lg.mu.
+func (lg *Log) totalStoreBytesLocked() int64 {
+	var total int64
+	for _, s := range lg.segments {
+		total += int64(s.store.size)
+	}
+	return total
+}
+
+func (lg *Log) retirementReason(s *segment) string {
+	r := lg.Config.Retention
+	if r.MaxAge > 0 && time.Since(s.CreatedAt()) > r.MaxAge {
+		return "max_age"
+	}
+	if r.MaxSegments > 0 {
+		return "max_segments"
+	}
+	return "max_bytes"
+}
+
+// emitRetentionEvent sends ev on RetentionEvents without blocking Retire
+// if nobody's listening; a full channel just drops the oldest-pending
+// notification's slot, matching the "best-effort observability" contract
+// implied by returning a channel rather than requiring a callback.
+func (lg *Log) emitRetentionEvent(ev RetentionEvent) {
+	select {
+	case lg.retentionEvents <- ev:
+	default:
+	}
+}
+
+// RetentionEvents returns the channel the retention loop publishes to.
+// Returns nil (a channel that always blocks) when Config.Retention isn't
+// configured.
+func (lg *Log) RetentionEvents() <-chan RetentionEvent {
+	return lg.retentionEvents
+}
+
+// stopRetentionLoop stops the retention goroutine and waits for it to
+// exit. A no-op when retention was never started.
+func (lg *Log) stopRetentionLoop() {
+	if lg.retentionStop == nil {
+		return
+	}
+	close(lg.retentionStop)
+	<-lg.retentionDone
+}
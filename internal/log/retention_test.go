@@ -0,0 +1,85 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionMaxSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-max-segments-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxSegments = 2
+
+	lg, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer lg.Close()
+
+	for i := 0; i < 6; i++ {
+		_, err := lg.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(lg.segments), 2)
+
+	// call the policy evaluation directly rather than waiting on the
+	// background loop's ticker
+	lg.applyRetention()
+	require.LessOrEqual(t, len(lg.segments), 2)
+
+	select {
+	case ev := <-lg.RetentionEvents():
+		require.Equal(t, "max_segments", ev.Reason)
+	default:
+		t.Fatal("expected a retention event for the retired segment")
+	}
+}
+
+func TestRetentionMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-max-bytes-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxBytes = 40
+
+	lg, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer lg.Close()
+
+	for i := 0; i < 6; i++ {
+		_, err := lg.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	lg.applyRetention()
+
+	lg.mu.Lock()
+	total := lg.totalStoreBytesLocked()
+	lg.mu.Unlock()
+	// the active segment alone can still exceed MaxBytes; retention only
+	// promises to stop retiring once the remainder fits
+	require.LessOrEqual(t, total, c.Retention.MaxBytes+int64(c.Segment.MaxStoreBytes))
+}
+
+// TestRetentionDisabledByDefault confirms a Log with no Retention
+// configured never starts the background loop, matching the log's
+// original behavior of only removing segments via an explicit Truncate.
+func TestRetentionDisabledByDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-disabled-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lg, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer lg.Close()
+
+	require.Nil(t, lg.RetentionEvents())
+}
@@ -1,11 +1,15 @@
 package log
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	api "github.com/aleksandarhr/proglog/api/v1"
+	"github.com/aleksandarhr/proglog/internal/log/index/trigram"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -20,55 +24,102 @@ type segment struct {
 	store *store
 	index *index
 
+	// backend is what created this segment's store/index files, and is
+	// used again by Remove to delete them the same way they were opened.
+	backend SegmentBackend
+
+	// tri is the segment's trigram posting list, used by Log.Search. It's
+	// optional: nil when the segment's backing ".tri" file couldn't be
+	// opened, in which case the segment is simply excluded from search.
+	tri *trigram.Index
+
 	// needed to know what offset to append new records under
 	//	and to calculate the relative offsets for the index entries
 	baseOffset, nextOffset uint64
 
+	// createdAt is when this segment was first created, persisted to a
+	// ".meta" file so it survives restarts and Retention's MaxAge policy
+	// still has something to compare against
+	createdAt time.Time
+
 	// allows to copmare the store file and index sizes to
 	//	the configured limits to know when the segment is maxed out
 	config Config
 }
 
 // newSegment creates a new segment (e.g. when the current active segment hits its max size)
-func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+func newSegment(backend SegmentBackend, baseOffset uint64, c Config) (*segment, error) {
 	s := &segment{
 		baseOffset: baseOffset,
 		config:     c,
+		backend:    backend,
 	}
 
-	var err error
-	// open the store file
-	// O_CREATE --> create it if it does not exist)
-	// O_APPEND --> make the os append to the file when writing
-	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
-		0644,
-	)
+	// open the store file through the backend, so tests and non-local
+	// deployments can supply something other than a plain os.OpenFile
+	storeRWC, err := backend.OpenStore(baseOffset)
 	if err != nil {
 		return nil, err
 	}
+	storeFile, ok := storeRWC.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf(
+			"segment: store for base offset %d is not backed by a local file; "+
+				"store's buffered writes need direct os.File access", baseOffset,
+		)
+	}
 
-	// create the store with the store file
-	if s.store, err = newStore(storeFile); err != nil {
+	// create the store with the store file. A configured Codec takes
+	// precedence over ChecksumAlgo: the two framings are mutually
+	// exclusive, and compression is the more deliberate opt-in of the two.
+	if c.Segment.Codec != nil {
+		s.store, err = newStoreWithCodec(storeFile, c.Segment.Codec)
+	} else {
+		s.store, err = newStoreWithChecksum(storeFile, c.Segment.ChecksumAlgo)
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	// open the index file (or create it if it does not exist)
-	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
-		os.O_RDWR|os.O_CREATE,
-		0644,
-	)
+	// open the index file (or create it if it does not exist) through the backend
+	indexRWC, err := backend.OpenIndex(baseOffset)
 	if err != nil {
 		return nil, err
 	}
+	indexFile, ok := indexRWC.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf(
+			"segment: index for base offset %d is not backed by a local file; "+
+				"index needs direct os.File access to memory-map it", baseOffset,
+		)
+	}
 
 	// create the index with the index file
 	if s.index, err = newIndex(indexFile, c); err != nil {
 		return nil, err
 	}
 
+	// load the trigram posting list if a prior run persisted one for this
+	// segment; otherwise start empty and build it up as records are
+	// appended. The trigram index sits alongside the store/index files on
+	// local disk regardless of backend, since SegmentBackend doesn't (yet)
+	// manage it.
+	triPath := s.triPath()
+	if _, statErr := os.Stat(triPath); statErr == nil {
+		if s.tri, err = trigram.Open(triPath); err != nil {
+			return nil, err
+		}
+	} else {
+		s.tri = trigram.New(triPath)
+	}
+
+	// load (or record, if this is the first time) when the segment was
+	// created, so Retention's MaxAge policy has something to compare
+	// against even after a restart
+	if err := s.loadOrWriteCreatedAt(); err != nil {
+		return nil, err
+	}
+
 	// set the segment's next offset to prepare for the next appended recrod
 	if off, _, err := s.index.Read(-1); err != nil {
 		// if the index is empty, the first record and its offset are the segment's base offset
@@ -109,6 +160,9 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 		return 0, err
 	}
 
+	// index the record's value for full-text search
+	s.tri.Add(currOffset, record.Value)
+
 	// increment the segment's next offset
 	s.nextOffset++
 	return currOffset, nil
@@ -152,16 +206,67 @@ func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
 	}
-	if err := os.Remove(s.index.Name()); err != nil {
+	if err := s.backend.Remove(s.baseOffset); err != nil {
+		return err
+	}
+	if err := os.Remove(s.triPath()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	if err := os.Remove(s.store.Name()); err != nil {
+	if err := os.Remove(s.metaPath()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
 }
 
+// triPath returns the segment's trigram posting list file, which may not
+// exist yet if the segment has never been closed.
+func (s *segment) triPath() string {
+	dir := path.Dir(s.store.Name())
+	return path.Join(dir, fmt.Sprintf("%d%s", s.baseOffset, ".tri"))
+}
+
+// metaPath returns the segment's small metadata file, currently just its
+// creation timestamp.
+func (s *segment) metaPath() string {
+	dir := path.Dir(s.store.Name())
+	return path.Join(dir, fmt.Sprintf("%d%s", s.baseOffset, ".meta"))
+}
+
+// CreatedAt returns when the segment was first created, read from its
+// ".meta" file (or, on a segment created fresh, exactly what
+// loadOrWriteCreatedAt wrote a moment ago).
+func (s *segment) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// loadOrWriteCreatedAt reads the segment's ".meta" file if a prior run
+// wrote one, or stamps a new one with the current time otherwise.
+func (s *segment) loadOrWriteCreatedAt() error {
+	metaPath := s.metaPath()
+	if b, err := ioutil.ReadFile(metaPath); err == nil && len(b) == 8 {
+		s.createdAt = time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+		return nil
+	}
+
+	s.createdAt = time.Now()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(s.createdAt.UnixNano()))
+	return ioutil.WriteFile(metaPath, b, 0644)
+}
+
+// Sync fsyncs the segment's store and index, satisfying the durability
+// requirement of any record already appended to it.
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
 func (s *segment) Close() error {
+	if err := s.tri.Persist(); err != nil {
+		return err
+	}
 	if err := s.index.Close(); err != nil {
 		return err
 	}
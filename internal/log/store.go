@@ -2,9 +2,18 @@ package log
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/tysonmote/gommap"
 )
 
 // the encoding to persist record sizes and index entries in
@@ -17,17 +26,179 @@ const (
 	lenWidth = 8
 )
 
+// crc32cTable is the Castagnoli CRC-32 table, the variant used by most
+// storage systems (and the `crc32c` hardware instruction) rather than the
+// IEEE polynomial crc32.ChecksumIEEE would use.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptRecord is returned by Read/Verify when a record's checksum
+// doesn't match its data, e.g. after a torn write left a partially
+// flushed record on disk.
+var ErrCorruptRecord = errors.New("store: corrupt record")
+
+// ChecksumAlgo selects how (or whether) store.Append protects each
+// record with a checksum that store.Read verifies.
+type ChecksumAlgo int
+
+const (
+	// ChecksumNone writes no checksum, matching the store's original
+	// on-disk format. The default, so existing segments keep working.
+	ChecksumNone ChecksumAlgo = iota
+	// ChecksumCRC32C checksums each record with a 4-byte CRC32C.
+	ChecksumCRC32C
+	// ChecksumXXHash64 checksums each record with an 8-byte xxhash64, for
+	// deployments that would rather spend a faster hash than CRC32C's
+	// table lookups.
+	ChecksumXXHash64
+)
+
+// width returns how many bytes this algorithm's checksum occupies in a
+// record's header, 0 for ChecksumNone.
+func (a ChecksumAlgo) width() int {
+	switch a {
+	case ChecksumCRC32C:
+		return 4
+	case ChecksumXXHash64:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func (a ChecksumAlgo) sum(data []byte) uint64 {
+	switch a {
+	case ChecksumCRC32C:
+		return uint64(crc32.Checksum(data, crc32cTable))
+	case ChecksumXXHash64:
+		return xxhash.Sum64(data)
+	default:
+		return 0
+	}
+}
+
 // store is a wrapper around a file
 type store struct {
 	File *os.File
 	mu   sync.Mutex
 	size uint64
 	buf  *bufio.Writer
+
+	// checksum is the algorithm every record in this store is protected
+	// with. It's fixed for the store's lifetime: unlike the codec used
+	// for compression, mixing checksum algorithms within one segment
+	// would mean Read can't know which width to expect without yet
+	// another on-disk marker.
+	checksum ChecksumAlgo
+
+	// codec is the codec new Appends compress records with. nil means
+	// Append/Read use the plain (optionally checksummed) framing instead
+	// of the codec framing; the two are mutually exclusive per store.
+	// Existing records are always decoded with whatever codec their own
+	// header names, via codecsByID, regardless of this field.
+	codec Codec
+
+	// mmap is a read-only mapping of the store's file, used by readAt to
+	// serve hot reads without a syscall per call. nil when the store
+	// wasn't created with StoreOptions.Mmap, in which case every read
+	// goes through File.ReadAt as before.
+	mmap gommap.MMap
+	// mmapMaxSize is how far past s.size remapLocked maps ahead of time,
+	// so ordinary growth doesn't force a remap on every Append.
+	mmapMaxSize uint64
+
+	// restore is non-nil for a store created with newStoreForRestore,
+	// whose bytes arrive out of order via AppendAt rather than
+	// sequentially via Append. It tracks which ranges have been written
+	// so Read can refuse to serve a record until it's complete.
+	restore *restoreState
 }
 
-// newStore creates a new store with the provided file
+// StoreOptions configures optional store behavior beyond what
+// newStore/newStoreWithChecksum/newStoreWithCodec default to.
+type StoreOptions struct {
+	// Mmap memory-maps the file for reads, so Read/ReadAt can serve hot
+	// records without a syscall per call. A read that falls outside the
+	// mapped region (the not-yet-flushed bufio.Writer tail, or growth
+	// that hasn't triggered a remap yet) transparently falls back to
+	// File.ReadAt.
+	Mmap bool
+	// MmapMaxSize is how much headroom past the store's current size to
+	// map ahead of time. Defaults to defaultMmapMaxSize when left zero.
+	MmapMaxSize uint64
+}
+
+// defaultMmapMaxSize is the headroom newStoreWithOptions maps ahead of the
+// store's current size when StoreOptions.MmapMaxSize is left zero.
+const defaultMmapMaxSize = 1 << 20 // 1MB
+
+// newStoreWithOptions creates a new store with the provided file and opts.
+func newStoreWithOptions(f *os.File, opts StoreOptions) (*store, error) {
+	s, err := newStore(f)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Mmap {
+		s.mmapMaxSize = opts.MmapMaxSize
+		if s.mmapMaxSize == 0 {
+			s.mmapMaxSize = defaultMmapMaxSize
+		}
+		if err := s.remapLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// remapLocked (re)memory-maps the file out to s.size plus s.mmapMaxSize of
+// headroom, so later Appends don't force a remap until they've grown past
+// that headroom. A no-op if the current mapping already covers that much.
+// Callers must hold s.mu.
+func (s *store) remapLocked() error {
+	target := s.size + s.mmapMaxSize
+	if s.mmap != nil && uint64(len(s.mmap)) >= target {
+		return nil
+	}
+	if s.mmap != nil {
+		if err := s.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		s.mmap = nil
+	}
+	// Grow the file out to the mapped region's size; the real data stays
+	// exactly where it is, and the padding is harmless since nothing
+	// beyond s.size is ever read or trusted.
+	if err := os.Truncate(s.File.Name(), int64(target)); err != nil {
+		return err
+	}
+	mmap, err := gommap.Map(s.File.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	s.mmap = mmap
+	return nil
+}
+
+// readAt copies len(p) bytes starting at off into p, preferring the
+// memory-mapped region when the read falls entirely inside it and falling
+// back to File.ReadAt otherwise. It preserves File.ReadAt's (n, err) pair
+// rather than discarding n, since a caller reading past the end of the
+// file (e.g. io.MultiReader probing for more data) needs the partial
+// count that goes with io.EOF. Callers must hold s.mu.
+func (s *store) readAt(p []byte, off int64) (int, error) {
+	if s.mmap != nil && off >= 0 && uint64(off)+uint64(len(p)) <= uint64(len(s.mmap)) {
+		copy(p, s.mmap[off:uint64(off)+uint64(len(p))])
+		return len(p), nil
+	}
+	return s.File.ReadAt(p, off)
+}
+
+// newStore creates a new store with the provided file, with no checksum
+// protection and no compression, matching the store's original behavior.
 func newStore(f *os.File) (*store, error) {
-	fi, err := os.Stat(f.Name())
+	// stat the fd rather than the path: a backend (e.g. MemoryBackend)
+	// may have already unlinked the path, in which case only the fd is
+	// still good for anything
+	fi, err := f.Stat()
 	// error with the file provided, cannot create a store
 	if err != nil {
 		return nil, err
@@ -42,31 +213,255 @@ func newStore(f *os.File) (*store, error) {
 	}, nil
 }
 
-// Append persists the given bytes to the store
+// newStoreWithChecksum creates a new store with the provided file,
+// checksumming every record appended to it with algo and verifying that
+// checksum on every Read.
+func newStoreWithChecksum(f *os.File, algo ChecksumAlgo) (*store, error) {
+	s, err := newStore(f)
+	if err != nil {
+		return nil, err
+	}
+	s.checksum = algo
+	return s, nil
+}
+
+// newStoreWithCodec creates a new store with the provided file that
+// transparently compresses every record appended to it with codec. Reads
+// decode with whichever codec the record's own header names, so a
+// segment can keep working even if its store is later reopened with a
+// different codec configured (or none at all).
+func newStoreWithCodec(f *os.File, codec Codec) (*store, error) {
+	s, err := newStore(f)
+	if err != nil {
+		return nil, err
+	}
+	s.codec = codec
+	return s, nil
+}
+
+// Append persists the given bytes to the store, preceded by an 8-byte
+// length and, unless checksum is ChecksumNone, the record's checksum (or,
+// if the store was created with a codec, the codec framing instead: the
+// two are mutually exclusive per store).
 func (s *store) Append(toPersist []byte) (bytesWritten uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// get current position where the bytes will be persisted
+	if s.restore != nil {
+		return 0, 0, fmt.Errorf("store: Append not supported on a restore-mode store; use AppendAt")
+	}
+
 	pos = s.size
+	total, err := s.frameRecord(s.buf, toPersist)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.size += total
+	// return the number of bytes written
+	// and the position where the store holds the record (to be used for indexing)
+	return total, pos, nil
+}
+
+// AppendBatch persists every record in records in one lock acquisition and
+// one write to the underlying buffered writer, instead of paying Append's
+// per-record lock/syscall overhead once per record. It returns each
+// record's starting position, in the same order as records, so callers can
+// bulk-populate the index in one shot.
+func (s *store) AppendBatch(records [][]byte) (positions []uint64, totalBytes uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.restore != nil {
+		return nil, 0, fmt.Errorf("store: AppendBatch not supported on a restore-mode store; use AppendAt")
+	}
+
+	positions = make([]uint64, len(records))
+	var scratch bytes.Buffer
+	pos := s.size
+	for i, toPersist := range records {
+		positions[i] = pos
+		n, err := s.frameRecord(&scratch, toPersist)
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+	}
 
+	n, err := s.buf.Write(scratch.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	totalBytes = uint64(n)
+	s.size += totalBytes
+	return positions, totalBytes, nil
+}
+
+// frameRecord writes toPersist's on-disk frame to w and returns the
+// frame's total length. Callers must hold s.mu.
+func (s *store) frameRecord(w io.Writer, toPersist []byte) (uint64, error) {
+	if s.codec != nil {
+		return s.frameRecordWithCodec(w, toPersist)
+	}
+	return s.frameRecordPlain(w, toPersist)
+}
+
+// frameRecordPlain writes toPersist's length and, unless checksum is
+// ChecksumNone, its checksum, followed by toPersist itself. Callers must
+// hold s.mu.
+func (s *store) frameRecordPlain(w io.Writer, toPersist []byte) (uint64, error) {
 	// write the length of the record so we know how many bytes to read later
-	if err := binary.Write(s.buf, enc, uint64(len(toPersist))); err != nil {
-		return 0, 0, err
+	if err := binary.Write(w, enc, uint64(len(toPersist))); err != nil {
+		return 0, err
+	}
+	headerBytes := uint64(lenWidth)
+
+	if width := s.checksum.width(); width > 0 {
+		sum := s.checksum.sum(toPersist)
+		var err error
+		if width == 4 {
+			err = binary.Write(w, enc, uint32(sum))
+		} else {
+			err = binary.Write(w, enc, sum)
+		}
+		if err != nil {
+			return 0, err
+		}
+		headerBytes += uint64(width)
 	}
 
-	// write to the buffered writer to reduce system calls and improve performance
-	numBytes, err := s.buf.Write(toPersist)
+	numBytes, err := w.Write(toPersist)
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
+	// At pos --> record_length (8 bytes), optionally a checksum, then the record data itself
+	return uint64(numBytes) + headerBytes, nil
+}
 
-	numBytes += lenWidth
-	s.size += uint64(numBytes)
-	// return the number of bytes written
-	// and the position where the store holds the record (to be used for indexing)
-	// At pos --> record_length (8 bytes) followed by the record data itself
-	return uint64(numBytes), pos, nil
+// frameRecordWithCodec writes a codec-compressed record, framed as an
+// 8-byte uncompressed length, a 1-byte codec id, an 8-byte compressed
+// length, and the compressed bytes themselves. The codec id travels with
+// every record so a segment stays readable even if records were appended
+// under different codecs over its lifetime (e.g. across a codec config
+// change). Callers must hold s.mu.
+func (s *store) frameRecordWithCodec(w io.Writer, toPersist []byte) (uint64, error) {
+	compressed, err := s.codec.Encode(toPersist)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := binary.Write(w, enc, uint64(len(toPersist))); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write([]byte{s.codec.ID()}); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, enc, uint64(len(compressed))); err != nil {
+		return 0, err
+	}
+
+	numBytes, err := w.Write(compressed)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(lenWidth+1+lenWidth+numBytes), nil
+}
+
+// readRecordAt reads the record starting at pos, verifying its checksum
+// if one is configured. Callers must hold s.mu and have already flushed
+// s.buf.
+func (s *store) readRecordAt(pos uint64) (data []byte, recordLen uint64, err error) {
+	if err := s.checkRangeCoveredLocked(Range{Start: pos, End: pos + lenWidth}); err != nil {
+		return nil, 0, err
+	}
+	size := make([]byte, lenWidth)
+	if _, err := s.readAt(size, int64(pos)); err != nil {
+		return nil, 0, err
+	}
+	dataLen := enc.Uint64(size)
+	headerBytes := uint64(lenWidth)
+
+	var wantSum uint64
+	if width := s.checksum.width(); width > 0 {
+		if err := s.checkRangeCoveredLocked(Range{Start: pos + headerBytes, End: pos + headerBytes + uint64(width)}); err != nil {
+			return nil, 0, err
+		}
+		sumBuf := make([]byte, width)
+		if _, err := s.readAt(sumBuf, int64(pos+headerBytes)); err != nil {
+			return nil, 0, err
+		}
+		if width == 4 {
+			wantSum = uint64(enc.Uint32(sumBuf))
+		} else {
+			wantSum = enc.Uint64(sumBuf)
+		}
+		headerBytes += uint64(width)
+	}
+
+	if err := s.checkRangeCoveredLocked(Range{Start: pos + headerBytes, End: pos + headerBytes + dataLen}); err != nil {
+		return nil, 0, err
+	}
+	data = make([]byte, dataLen)
+	if _, err := s.readAt(data, int64(pos+headerBytes)); err != nil {
+		return nil, 0, err
+	}
+
+	if s.checksum.width() > 0 && s.checksum.sum(data) != wantSum {
+		return nil, 0, ErrCorruptRecord
+	}
+	return data, headerBytes + dataLen, nil
+}
+
+// readRecordWithCodec reads the codec-framed record starting at pos,
+// decoding it with whichever codec its own header names (via
+// codecsByID), regardless of which codec s is currently configured to
+// compress new Appends with. Callers must hold s.mu and have already
+// flushed s.buf.
+func (s *store) readRecordWithCodec(pos uint64) (data []byte, recordLen uint64, err error) {
+	headerWidth := uint64(lenWidth + 1 + lenWidth)
+	if err := s.checkRangeCoveredLocked(Range{Start: pos, End: pos + headerWidth}); err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, headerWidth)
+	if _, err := s.readAt(header, int64(pos)); err != nil {
+		return nil, 0, err
+	}
+	uncompressedLen := enc.Uint64(header[:lenWidth])
+	codecID := header[lenWidth]
+	compressedLen := enc.Uint64(header[lenWidth+1:])
+	headerBytes := uint64(len(header))
+
+	if err := s.checkRangeCoveredLocked(Range{Start: pos + headerBytes, End: pos + headerBytes + compressedLen}); err != nil {
+		return nil, 0, err
+	}
+	compressed := make([]byte, compressedLen)
+	if _, err := s.readAt(compressed, int64(pos+headerBytes)); err != nil {
+		return nil, 0, err
+	}
+
+	codec, ok := codecsByID[codecID]
+	if !ok {
+		return nil, 0, fmt.Errorf("store: record at byte offset %d has unknown codec id %d", pos, codecID)
+	}
+	if data, err = codec.Decode(compressed); err != nil {
+		return nil, 0, err
+	}
+	if uint64(len(data)) != uncompressedLen {
+		return nil, 0, fmt.Errorf(
+			"store: record at byte offset %d decoded to %d bytes, want %d",
+			pos, len(data), uncompressedLen,
+		)
+	}
+	return data, headerBytes + compressedLen, nil
+}
+
+// readAnyRecordAt reads the record at pos using whichever framing this
+// store's records were written with. Callers must hold s.mu and have
+// already flushed s.buf.
+func (s *store) readAnyRecordAt(pos uint64) ([]byte, uint64, error) {
+	if s.codec != nil {
+		return s.readRecordWithCodec(pos)
+	}
+	return s.readRecordAt(pos)
 }
 
 func (s *store) Read(pos uint64) ([]byte, error) {
@@ -78,22 +473,49 @@ func (s *store) Read(pos uint64) ([]byte, error) {
 		return nil, err
 	}
 
-	// find out how many bytes we need to read to get the whole record
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
+	data, _, err := s.readAnyRecordAt(pos)
+	return data, err
+}
+
+// RecordStat is the header information store.Stat reads for a single
+// record without decompressing it: enough for a caller to size a buffer
+// or decide whether decoding is worth it.
+type RecordStat struct {
+	// CodecID is the codec the record was compressed with, 0 (NoopCodec)
+	// for records written before codecs existed or under no compression.
+	CodecID byte
+	// UncompressedLen is the record's original, pre-compression length.
+	UncompressedLen uint64
+}
+
+// Stat reads the record at pos's codec id and uncompressed length without
+// decompressing it, so callers like the segment/index layer can size
+// buffers up front.
+func (s *store) Stat(pos uint64) (RecordStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return RecordStat{}, err
 	}
 
-	// fetch the record of size 'size'
-	bytes := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(bytes, int64(pos+lenWidth)); err != nil {
-		return nil, err
+	if s.codec == nil {
+		size := make([]byte, lenWidth)
+		if _, err := s.readAt(size, int64(pos)); err != nil {
+			return RecordStat{}, err
+		}
+		return RecordStat{CodecID: NoopCodec{}.ID(), UncompressedLen: enc.Uint64(size)}, nil
 	}
 
-	return bytes, nil
+	header := make([]byte, lenWidth+1)
+	if _, err := s.readAt(header, int64(pos)); err != nil {
+		return RecordStat{}, err
+	}
+	return RecordStat{CodecID: header[lenWidth], UncompressedLen: enc.Uint64(header[:lenWidth])}, nil
 }
 
-// ReadAt reads len(p) bytes into p beginning at the off offset in the store's file
+// ReadAt reads len(p) bytes into p beginning at the off offset in the
+// store's file, preferring the memory-mapped region when mmap is enabled.
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -102,19 +524,110 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 		return 0, err
 	}
 
-	return s.File.ReadAt(p, off)
+	return s.readAt(p, off)
+}
+
+// Verify scans the store end-to-end, checking every record's checksum (if
+// any), and reports the byte offset of the first corrupt record it finds.
+// Useful after a crash, before trusting a segment that might have a torn
+// write at its tail.
+func (s *store) Verify(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+
+	var pos uint64
+	for pos < s.size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, n, err := s.readAnyRecordAt(pos)
+		if err != nil {
+			if errors.Is(err, ErrCorruptRecord) {
+				return fmt.Errorf("%w: at byte offset %d", ErrCorruptRecord, pos)
+			}
+			return fmt.Errorf("store: reading record at byte offset %d: %w", pos, err)
+		}
+		pos += n
+	}
+	return nil
+}
+
+// Truncate rewinds the store to pos, discarding everything after it. Used
+// to recover from a torn write: callers run Verify, find the offset of
+// the first bad record, and Truncate back to the last known-good one.
+func (s *store) Truncate(pos uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(pos)); err != nil {
+		return err
+	}
+	s.size = pos
+	s.buf = bufio.NewWriter(s.File)
+
+	if s.mmap != nil {
+		// the old mapping covers data that no longer exists; drop it and
+		// remap fresh over the truncated file
+		if err := s.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		s.mmap = nil
+		if err := s.remapLocked(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Close persists any buffered data before closing the file
+// Sync flushes the buffered writer and fsyncs the file, so the durability
+// flusher can guarantee Append's data has reached stable storage without
+// closing the store. If mmap is enabled, it also remaps so readers pick up
+// records appended since the last remap.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	if s.mmap != nil {
+		return s.remapLocked()
+	}
+	return nil
+}
+
+// Close persists any buffered data before closing the file. If mmap is
+// enabled, it unmaps and truncates away the unused headroom remapLocked
+// mapped ahead of the store's real size, so the file's size on disk
+// reflects only real data again.
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	err := s.buf.Flush()
-	if err != nil {
+	if err := s.buf.Flush(); err != nil {
 		return err
 	}
 
+	if s.mmap != nil {
+		if err := s.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		s.mmap = nil
+		if err := s.File.Truncate(int64(s.size)); err != nil {
+			return err
+		}
+	}
+
 	return s.File.Close()
 }
 
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	api "github.com/aleksandarhr/proglog/api/v1"
+	"google.golang.org/grpc"
+)
+
+// CommitLog is the subset of *log.Log the gRPC server depends on, so this
+// package doesn't need to import internal/log directly and can be tested
+// against a fake.
+type CommitLog interface {
+	Append(*api.Record) (uint64, error)
+	Read(uint64) (*api.Record, error)
+	WaitForOffset(ctx context.Context, offset uint64) error
+}
+
+// GRPCConfig wires the gRPC server to the log it serves.
+type GRPCConfig struct {
+	CommitLog CommitLog
+}
+
+var _ api.LogServer = (*grpcServer)(nil)
+
+// grpcServer implements api.LogServer on top of a CommitLog. It's the gRPC
+// analogue of httpServer: same log, same Produce/Consume semantics, just a
+// different wire protocol and, here, streaming.
+type grpcServer struct {
+	api.UnimplementedLogServer
+	*GRPCConfig
+}
+
+func newGRPCServer(config *GRPCConfig) *grpcServer {
+	return &grpcServer{GRPCConfig: config}
+}
+
+// NewGRPCServer creates a gRPC server, registers the Log service on it, and
+// returns it ready for the caller to Serve. It runs alongside NewHTTPServer
+// rather than replacing it.
+func NewGRPCServer(config *GRPCConfig, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	gsrv := grpc.NewServer(opts...)
+	api.RegisterLogServer(gsrv, newGRPCServer(config))
+	return gsrv, nil
+}
+
+func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	offset, err := s.CommitLog.Append(req.Record)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ProduceResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	record, err := s.CommitLog.Read(req.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ConsumeResponse{Record: record}, nil
+}
+
+// ProduceStream lets a client stream records in and get an offset back for
+// each one, without paying a round trip per record.
+func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := s.Produce(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// ConsumeStream sends every record from req.Offset onward, then follows the
+// tail of the log: once it catches up to CommitLog's highest offset it
+// blocks on WaitForOffset instead of returning an out-of-range error, so a
+// subscriber sees new records as Append commits them.
+func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+	for {
+		if err := s.CommitLog.WaitForOffset(ctx, req.Offset); err != nil {
+			return err
+		}
+
+		res, err := s.Consume(ctx, req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+		req.Offset++
+	}
+}
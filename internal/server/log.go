@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Log is a simple, in-memory, append-only list of records, guarded by a
+// mutex since handleProduce/handleConsume run concurrently across
+// requests. It exists purely to back the JSON/HTTP API; the gRPC server
+// defers to the real segment-backed log instead.
+type Log struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewLog returns a Log with no records.
+func NewLog() *Log {
+	return &Log{}
+}
+
+// Append adds record to the log and returns the offset it was stored
+// under, which is always the previous length of the log.
+func (c *Log) Append(record Record) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	record.Offset = uint64(len(c.records))
+	c.records = append(c.records, record)
+	return record.Offset, nil
+}
+
+// ErrOffsetNotFound is returned by Read when offset is beyond the log's
+// current length.
+var ErrOffsetNotFound = fmt.Errorf("offset not found")
+
+// Read returns the record stored at offset.
+func (c *Log) Read(offset uint64) (Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset >= uint64(len(c.records)) {
+		return Record{}, ErrOffsetNotFound
+	}
+	return c.records[offset], nil
+}
+
+// Record is a single value stored in the log, along with the offset it was
+// assigned on Append.
+type Record struct {
+	Value  []byte `json:"value"`
+	Offset uint64 `json:"offset"`
+}